@@ -0,0 +1,184 @@
+package queryer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	defaultMemoTTL      = 30 * time.Second
+	defaultMemoCapacity = 1000
+)
+
+var keyCacheName = tag.MustNewKey("cache_name")
+
+var (
+	mCacheHits      = stats.Int64("queryer/cache_hits", "memoized queryer cache hits", stats.UnitDimensionless)
+	mCacheMisses    = stats.Int64("queryer/cache_misses", "memoized queryer cache misses", stats.UnitDimensionless)
+	mCacheEvictions = stats.Int64("queryer/cache_evictions", "memoized queryer cache evictions", stats.UnitDimensionless)
+)
+
+func init() {
+	// A registration failure here (e.g. a duplicate view name registered
+	// elsewhere, or a re-run test binary) shouldn't take down the whole
+	// process over what's ultimately just metrics; log it and carry on
+	// without the views rather than panicking from an init func.
+	if err := registerViews(); err != nil {
+		log.Printf("queryer: failed to register metrics views: %v", err)
+	}
+}
+
+func registerViews() error {
+	views := []*view.View{
+		{Name: "queryer/cache_hits", Measure: mCacheHits, TagKeys: []tag.Key{keyCacheName}, Aggregation: view.Count()},
+		{Name: "queryer/cache_misses", Measure: mCacheMisses, TagKeys: []tag.Key{keyCacheName}, Aggregation: view.Count()},
+		{Name: "queryer/cache_evictions", Measure: mCacheEvictions, TagKeys: []tag.Key{keyCacheName}, Aggregation: view.Count()},
+	}
+
+	return view.Register(views...)
+}
+
+// memoEntry is a single cached value, tracked for TTL expiry and LRU
+// eviction ordering.
+type memoEntry struct {
+	value    interface{}
+	expires  time.Time
+	lastUsed time.Time
+}
+
+// memoCache is a TTL-expiring, LRU-capped cache used for CacheQueryer's
+// memoized lookups (children, podsForServices, owner). Concurrent callers
+// requesting the same key coalesce onto a single fill via singleflight
+// instead of serializing behind one coarse mutex, which is what the queryer
+// did before this existed.
+type memoCache struct {
+	name string
+	ttl  time.Duration
+	cap  int
+
+	mu      sync.RWMutex
+	entries map[interface{}]*memoEntry
+
+	group singleflight.Group
+}
+
+func newMemoCache(name string, ttl time.Duration, cap int) *memoCache {
+	return &memoCache{
+		name:    name,
+		ttl:     ttl,
+		cap:     cap,
+		entries: make(map[interface{}]*memoEntry),
+	}
+}
+
+// getOrFill returns the cached value for key, calling fill to populate the
+// cache on a miss or expiry. Concurrent callers for the same key share a
+// single fill.
+func (m *memoCache) getOrFill(ctx context.Context, key interface{}, fill func() (interface{}, error)) (interface{}, error) {
+	if value, ok := m.get(key); ok {
+		m.record(ctx, mCacheHits)
+		return value, nil
+	}
+
+	m.record(ctx, mCacheMisses)
+
+	value, err, _ := m.group.Do(fmt.Sprintf("%+v", key), func() (interface{}, error) {
+		if value, ok := m.get(key); ok {
+			return value, nil
+		}
+
+		value, err := fill()
+		if err != nil {
+			return nil, err
+		}
+
+		m.set(key, value)
+		return value, nil
+	})
+
+	return value, err
+}
+
+func (m *memoCache) get(key interface{}) (interface{}, bool) {
+	m.mu.RLock()
+	entry, ok := m.entries[key]
+	m.mu.RUnlock()
+
+	if !ok {
+		return nil, false
+	}
+
+	if time.Now().After(entry.expires) {
+		return nil, false
+	}
+
+	m.mu.Lock()
+	entry.lastUsed = time.Now()
+	m.mu.Unlock()
+
+	return entry.value, true
+}
+
+func (m *memoCache) set(key, value interface{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	m.entries[key] = &memoEntry{
+		value:    value,
+		expires:  now.Add(m.ttl),
+		lastUsed: now,
+	}
+
+	m.evictLocked()
+}
+
+// invalidate removes key, typically called from an informer-driven
+// Add/Update/Delete hook so a fresh lookup replaces the stale value instead
+// of waiting out the TTL.
+func (m *memoCache) invalidate(key interface{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.entries, key)
+}
+
+// evictLocked drops the least-recently-used entry once the cache is over
+// capacity. Callers must hold m.mu for writing.
+func (m *memoCache) evictLocked() {
+	if m.cap <= 0 || len(m.entries) <= m.cap {
+		return
+	}
+
+	var oldestKey interface{}
+	var oldest time.Time
+	for key, entry := range m.entries {
+		if oldestKey == nil || entry.lastUsed.Before(oldest) {
+			oldestKey = key
+			oldest = entry.lastUsed
+		}
+	}
+
+	if oldestKey != nil {
+		delete(m.entries, oldestKey)
+		stats.Record(context.Background(), mCacheEvictions.M(1))
+	}
+}
+
+func (m *memoCache) record(ctx context.Context, measure *stats.Int64Measure) {
+	ctx, err := tag.New(ctx, tag.Insert(keyCacheName, m.name))
+	if err != nil {
+		stats.Record(context.Background(), measure.M(1))
+		return
+	}
+
+	stats.Record(ctx, measure.M(1))
+}
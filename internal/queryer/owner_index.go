@@ -0,0 +1,362 @@
+package queryer
+
+import (
+	"context"
+	"sync"
+
+	"github.com/heptio/developer-dash/internal/cache"
+	cacheutil "github.com/heptio/developer-dash/internal/cache/util"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kLabels "k8s.io/apimachinery/pkg/labels"
+	kruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/discovery"
+	clientgocache "k8s.io/client-go/tools/cache"
+)
+
+// OwnerIndex maintains a reverse index from owner UID to owned object, kept
+// current by registering add/update/delete handlers on every discovered
+// resource's informer rather than listing every kind on every call. It also
+// maintains the Service<->Pod relationship consumed by PodsForService and
+// ServicesForPod. Once built, lookups against the index are O(1) instead of
+// the N-kinds List fan-out CacheQueryer otherwise performs.
+type OwnerIndex struct {
+	cache           cache.Cache
+	discoveryClient discovery.DiscoveryInterface
+
+	mu            sync.RWMutex
+	childrenByUID map[types.UID]map[types.UID]kruntime.Object
+	// ownerByChildUID tracks the single controller UID each child is
+	// currently filed under in childrenByUID, so a later reassociation
+	// knows what to remove — a child has at most one controller ref.
+	ownerByChildUID map[types.UID]types.UID
+
+	podsByServiceUID map[types.UID]map[types.UID]*corev1.Pod
+	servicesByPodUID map[types.UID]map[types.UID]*corev1.Service
+
+	servicesByNamespace map[string]map[types.UID]*corev1.Service
+	podsByNamespace     map[string]map[types.UID]*corev1.Pod
+}
+
+// NewOwnerIndex creates an OwnerIndex.
+func NewOwnerIndex(c cache.Cache, discoveryClient discovery.DiscoveryInterface) *OwnerIndex {
+	return &OwnerIndex{
+		cache:           c,
+		discoveryClient: discoveryClient,
+
+		childrenByUID:       make(map[types.UID]map[types.UID]kruntime.Object),
+		ownerByChildUID:     make(map[types.UID]types.UID),
+		podsByServiceUID:    make(map[types.UID]map[types.UID]*corev1.Pod),
+		servicesByPodUID:    make(map[types.UID]map[types.UID]*corev1.Service),
+		servicesByNamespace: make(map[string]map[types.UID]*corev1.Service),
+		podsByNamespace:     make(map[string]map[types.UID]*corev1.Pod),
+	}
+}
+
+// Start enumerates ServerResources and subscribes to the shared cache's
+// event stream for every listable+watchable namespaced kind, so the index
+// stays current for the lifetime of ctx without further discovery calls.
+func (oi *OwnerIndex) Start(ctx context.Context) error {
+	resourceLists, err := oi.discoveryClient.ServerResources()
+	if err != nil {
+		return errors.Wrap(err, "list server resources")
+	}
+
+	for _, resourceList := range resourceLists {
+		if resourceList == nil {
+			continue
+		}
+
+		for i := range resourceList.APIResources {
+			apiResource := resourceList.APIResources[i]
+			if !apiResource.Namespaced {
+				continue
+			}
+			if !containsString("watch", apiResource.Verbs) || !containsString("list", apiResource.Verbs) {
+				continue
+			}
+
+			key := cacheutil.Key{
+				APIVersion: resourceList.GroupVersion,
+				Kind:       apiResource.Kind,
+			}
+
+			if err := oi.cache.AddEventHandler(ctx, key, clientgocache.ResourceEventHandlerFuncs{
+				AddFunc:    func(obj interface{}) { oi.handleUpsert(obj) },
+				UpdateFunc: func(_, newObj interface{}) { oi.handleUpsert(newObj) },
+				DeleteFunc: func(obj interface{}) { oi.handleDelete(obj) },
+			}); err != nil {
+				return errors.Wrapf(err, "registering event handler for %+v", key)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (oi *OwnerIndex) handleUpsert(obj interface{}) {
+	object, ok := obj.(kruntime.Object)
+	if !ok {
+		return
+	}
+
+	accessor, err := meta.Accessor(object)
+	if err != nil {
+		return
+	}
+
+	oi.mu.Lock()
+	defer oi.mu.Unlock()
+
+	oi.reassociateOwnersLocked(accessor, object)
+
+	switch typed := decodeTyped(object).(type) {
+	case *corev1.Pod:
+		oi.upsertPodLocked(typed)
+	case *corev1.Service:
+		oi.upsertServiceLocked(typed)
+	}
+}
+
+// reassociateOwnersLocked files object under its controller owner reference
+// (the same relationship metav1.IsControlledBy checks) in childrenByUID,
+// first dropping it from whatever owner it was filed under on a prior
+// Add/Update so a re-parented (adopted or released) object doesn't linger
+// under its old owner until it's deleted outright. A non-controller owner
+// reference is not indexed, matching the fallback fan-out in
+// CacheQueryer.findChildren, which only ever matches the controller ref.
+// Callers must hold oi.mu.
+func (oi *OwnerIndex) reassociateOwnersLocked(accessor metav1.Object, object kruntime.Object) {
+	childUID := accessor.GetUID()
+	oi.dropOwnerLocked(childUID)
+
+	controller := metav1.GetControllerOf(accessor)
+	if controller == nil {
+		return
+	}
+
+	owned, ok := oi.childrenByUID[controller.UID]
+	if !ok {
+		owned = make(map[types.UID]kruntime.Object)
+		oi.childrenByUID[controller.UID] = owned
+	}
+	owned[childUID] = object
+	oi.ownerByChildUID[childUID] = controller.UID
+}
+
+// dropOwnerLocked removes childUID from whatever owner it's currently filed
+// under, cleaning up the owner's entry entirely once it has no children
+// left. Callers must hold oi.mu.
+func (oi *OwnerIndex) dropOwnerLocked(childUID types.UID) {
+	ownerUID, ok := oi.ownerByChildUID[childUID]
+	if !ok {
+		return
+	}
+
+	owned := oi.childrenByUID[ownerUID]
+	delete(owned, childUID)
+	if len(owned) == 0 {
+		delete(oi.childrenByUID, ownerUID)
+	}
+	delete(oi.ownerByChildUID, childUID)
+}
+
+// decodeTyped classifies object by its GroupVersionKind and decodes it to a
+// concrete *corev1.Pod or *corev1.Service, converting from unstructured
+// first if necessary — the shared cache hands event handlers
+// *unstructured.Unstructured regardless of kind, so a concrete
+// `object.(*corev1.Pod)` type assertion never matches. Returns object
+// itself, undecoded, for any other kind.
+func decodeTyped(object kruntime.Object) interface{} {
+	u, err := toUnstructuredObject(object)
+	if err != nil {
+		return object
+	}
+
+	gvk := u.GroupVersionKind()
+	if gvk.Group != "" || gvk.Version != "v1" {
+		return object
+	}
+
+	switch gvk.Kind {
+	case "Pod":
+		pod := &corev1.Pod{}
+		if err := kruntime.DefaultUnstructuredConverter.FromUnstructured(u.Object, pod); err != nil {
+			return object
+		}
+		return pod
+	case "Service":
+		svc := &corev1.Service{}
+		if err := kruntime.DefaultUnstructuredConverter.FromUnstructured(u.Object, svc); err != nil {
+			return object
+		}
+		return svc
+	default:
+		return object
+	}
+}
+
+func (oi *OwnerIndex) handleDelete(obj interface{}) {
+	if tombstone, ok := obj.(clientgocache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+
+	object, ok := obj.(kruntime.Object)
+	if !ok {
+		return
+	}
+
+	accessor, err := meta.Accessor(object)
+	if err != nil {
+		return
+	}
+
+	oi.mu.Lock()
+	defer oi.mu.Unlock()
+
+	oi.dropOwnerLocked(accessor.GetUID())
+
+	switch typed := decodeTyped(object).(type) {
+	case *corev1.Pod:
+		delete(oi.podsByNamespace[typed.Namespace], typed.UID)
+		for svcUID := range oi.servicesByPodUID[typed.UID] {
+			delete(oi.podsByServiceUID[svcUID], typed.UID)
+		}
+		delete(oi.servicesByPodUID, typed.UID)
+	case *corev1.Service:
+		delete(oi.servicesByNamespace[typed.Namespace], typed.UID)
+		for podUID := range oi.podsByServiceUID[typed.UID] {
+			delete(oi.servicesByPodUID[podUID], typed.UID)
+		}
+		delete(oi.podsByServiceUID, typed.UID)
+	}
+}
+
+// upsertPodLocked re-resolves which services (by selector) the pod matches,
+// overwriting any stale association from a previous version of the pod.
+// Callers must hold oi.mu.
+func (oi *OwnerIndex) upsertPodLocked(pod *corev1.Pod) {
+	byNS, ok := oi.podsByNamespace[pod.Namespace]
+	if !ok {
+		byNS = make(map[types.UID]*corev1.Pod)
+		oi.podsByNamespace[pod.Namespace] = byNS
+	}
+	byNS[pod.UID] = pod
+
+	for svcUID := range oi.servicesByPodUID[pod.UID] {
+		delete(oi.podsByServiceUID[svcUID], pod.UID)
+	}
+	delete(oi.servicesByPodUID, pod.UID)
+
+	for _, svc := range oi.servicesByNamespace[pod.Namespace] {
+		oi.associateLocked(svc, pod)
+	}
+}
+
+// upsertServiceLocked re-resolves which pods the service's selector matches,
+// overwriting any stale association from a previous version of the service.
+// Callers must hold oi.mu.
+func (oi *OwnerIndex) upsertServiceLocked(svc *corev1.Service) {
+	byNS, ok := oi.servicesByNamespace[svc.Namespace]
+	if !ok {
+		byNS = make(map[types.UID]*corev1.Service)
+		oi.servicesByNamespace[svc.Namespace] = byNS
+	}
+	byNS[svc.UID] = svc
+
+	for podUID := range oi.podsByServiceUID[svc.UID] {
+		delete(oi.servicesByPodUID[podUID], svc.UID)
+	}
+	delete(oi.podsByServiceUID, svc.UID)
+
+	for _, pod := range oi.podsByNamespace[svc.Namespace] {
+		oi.associateLocked(svc, pod)
+	}
+}
+
+// associateLocked records svc<->pod if the service's selector matches the
+// pod's labels. Callers must hold oi.mu.
+func (oi *OwnerIndex) associateLocked(svc *corev1.Service, pod *corev1.Pod) {
+	labelSelector, err := getSelector(svc)
+	if err != nil || labelSelector == nil {
+		return
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(labelSelector)
+	if err != nil || selector.Empty() || !selector.Matches(kLabels.Set(pod.Labels)) {
+		return
+	}
+
+	pods, ok := oi.podsByServiceUID[svc.UID]
+	if !ok {
+		pods = make(map[types.UID]*corev1.Pod)
+		oi.podsByServiceUID[svc.UID] = pods
+	}
+	pods[pod.UID] = pod
+
+	services, ok := oi.servicesByPodUID[pod.UID]
+	if !ok {
+		services = make(map[types.UID]*corev1.Service)
+		oi.servicesByPodUID[pod.UID] = services
+	}
+	services[svc.UID] = svc
+}
+
+// Children returns the indexed objects owned by owner, or false if the
+// index has no entries for it (callers should fall back to a cache list).
+func (oi *OwnerIndex) Children(owner metav1.Object) ([]kruntime.Object, bool) {
+	oi.mu.RLock()
+	defer oi.mu.RUnlock()
+
+	owned, ok := oi.childrenByUID[owner.GetUID()]
+	if !ok {
+		return nil, false
+	}
+
+	children := make([]kruntime.Object, 0, len(owned))
+	for _, child := range owned {
+		children = append(children, child)
+	}
+	return children, true
+}
+
+// PodsForService returns the indexed pods matching service's selector, or
+// false if the index doesn't yet know about service (callers should fall
+// back to a cache list).
+func (oi *OwnerIndex) PodsForService(service *corev1.Service) ([]*corev1.Pod, bool) {
+	oi.mu.RLock()
+	defer oi.mu.RUnlock()
+
+	if _, ok := oi.servicesByNamespace[service.Namespace][service.UID]; !ok {
+		return nil, false
+	}
+
+	matches := oi.podsByServiceUID[service.UID]
+	pods := make([]*corev1.Pod, 0, len(matches))
+	for _, pod := range matches {
+		pods = append(pods, pod)
+	}
+	return pods, true
+}
+
+// ServicesForPod returns the indexed services whose selector matches pod,
+// or false if the index doesn't yet know about pod (callers should fall
+// back to a cache list).
+func (oi *OwnerIndex) ServicesForPod(pod *corev1.Pod) ([]*corev1.Service, bool) {
+	oi.mu.RLock()
+	defer oi.mu.RUnlock()
+
+	if _, ok := oi.podsByNamespace[pod.Namespace][pod.UID]; !ok {
+		return nil, false
+	}
+
+	matches := oi.servicesByPodUID[pod.UID]
+	services := make([]*corev1.Service, 0, len(matches))
+	for _, svc := range matches {
+		services = append(services, svc)
+	}
+	return services, true
+}
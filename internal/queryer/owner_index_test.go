@@ -0,0 +1,184 @@
+package queryer
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	kruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+func toUnstructured(t *testing.T, object kruntime.Object) *unstructured.Unstructured {
+	t.Helper()
+
+	m, err := kruntime.DefaultUnstructuredConverter.ToUnstructured(object)
+	if err != nil {
+		t.Fatalf("converting %T to unstructured: %v", object, err)
+	}
+	return &unstructured.Unstructured{Object: m}
+}
+
+func testPod(namespace, name string, uid types.UID, labels map[string]string, owners []metav1.OwnerReference) *corev1.Pod {
+	return &corev1.Pod{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:       namespace,
+			Name:            name,
+			UID:             uid,
+			Labels:          labels,
+			OwnerReferences: owners,
+		},
+	}
+}
+
+func testService(namespace, name string, uid types.UID, selector map[string]string) *corev1.Service {
+	return &corev1.Service{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Service"},
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+			UID:       uid,
+		},
+		Spec: corev1.ServiceSpec{
+			Selector: selector,
+		},
+	}
+}
+
+func TestOwnerIndex_PodServiceMatching(t *testing.T) {
+	oi := NewOwnerIndex(nil, nil)
+
+	svc := testService("ns1", "svc-1", "svc-uid", map[string]string{"app": "foo"})
+	pod := testPod("ns1", "pod-1", "pod-uid", map[string]string{"app": "foo", "extra": "label"}, nil)
+
+	// Deliver both as unstructured, matching what the shared cache's
+	// informer stream actually hands event handlers.
+	oi.handleUpsert(toUnstructured(t, svc))
+	oi.handleUpsert(toUnstructured(t, pod))
+
+	pods, ok := oi.PodsForService(svc)
+	if !ok {
+		t.Fatal("PodsForService: ok = false, want true")
+	}
+	if len(pods) != 1 || pods[0].UID != "pod-uid" {
+		t.Fatalf("PodsForService = %+v, want [pod-uid]", pods)
+	}
+
+	services, ok := oi.ServicesForPod(pod)
+	if !ok {
+		t.Fatal("ServicesForPod: ok = false, want true")
+	}
+	if len(services) != 1 || services[0].UID != "svc-uid" {
+		t.Fatalf("ServicesForPod = %+v, want [svc-uid]", services)
+	}
+}
+
+func TestOwnerIndex_PodServiceMatching_noMatch(t *testing.T) {
+	oi := NewOwnerIndex(nil, nil)
+
+	svc := testService("ns1", "svc-1", "svc-uid", map[string]string{"app": "foo"})
+	pod := testPod("ns1", "pod-1", "pod-uid", map[string]string{"app": "bar"}, nil)
+
+	oi.handleUpsert(toUnstructured(t, svc))
+	oi.handleUpsert(toUnstructured(t, pod))
+
+	pods, ok := oi.PodsForService(svc)
+	if !ok {
+		t.Fatal("PodsForService: ok = false, want true (service is indexed, just with no matches)")
+	}
+	if len(pods) != 0 {
+		t.Fatalf("PodsForService = %+v, want none", pods)
+	}
+}
+
+func TestOwnerIndex_Children_controllerRefOnly(t *testing.T) {
+	oi := NewOwnerIndex(nil, nil)
+
+	child := testPod("ns1", "pod-1", "child-uid", nil, []metav1.OwnerReference{
+		{UID: "controller-uid", Controller: boolPtr(true)},
+		{UID: "other-owner-uid", Controller: boolPtr(false)},
+	})
+
+	oi.handleUpsert(toUnstructured(t, child))
+
+	children, ok := oi.Children(&metav1.ObjectMeta{UID: "controller-uid"})
+	if !ok || len(children) != 1 {
+		t.Fatalf("Children(controller) = %+v, %v, want one child", children, ok)
+	}
+
+	if _, ok := oi.Children(&metav1.ObjectMeta{UID: "other-owner-uid"}); ok {
+		t.Fatal("Children(non-controller owner) = ok=true, want false: only the controller ref should be indexed")
+	}
+}
+
+func TestOwnerIndex_Children_reassociationOnUpdate(t *testing.T) {
+	oi := NewOwnerIndex(nil, nil)
+
+	child := testPod("ns1", "pod-1", "child-uid", nil, []metav1.OwnerReference{
+		{UID: "owner-a", Controller: boolPtr(true)},
+	})
+	oi.handleUpsert(toUnstructured(t, child))
+
+	if children, ok := oi.Children(&metav1.ObjectMeta{UID: "owner-a"}); !ok || len(children) != 1 {
+		t.Fatalf("Children(owner-a) before reparent = %+v, %v, want one child", children, ok)
+	}
+
+	reparented := testPod("ns1", "pod-1", "child-uid", nil, []metav1.OwnerReference{
+		{UID: "owner-b", Controller: boolPtr(true)},
+	})
+	oi.handleUpsert(toUnstructured(t, reparented))
+
+	if _, ok := oi.Children(&metav1.ObjectMeta{UID: "owner-a"}); ok {
+		t.Fatal("Children(owner-a) after reparent = ok=true, want false: the stale association must be dropped")
+	}
+	if children, ok := oi.Children(&metav1.ObjectMeta{UID: "owner-b"}); !ok || len(children) != 1 {
+		t.Fatalf("Children(owner-b) after reparent = %+v, %v, want one child", children, ok)
+	}
+}
+
+func TestOwnerIndex_Children_evictedOnDelete(t *testing.T) {
+	oi := NewOwnerIndex(nil, nil)
+
+	child := testPod("ns1", "pod-1", "child-uid", nil, []metav1.OwnerReference{
+		{UID: "owner-a", Controller: boolPtr(true)},
+	})
+	u := toUnstructured(t, child)
+	oi.handleUpsert(u)
+
+	if _, ok := oi.Children(&metav1.ObjectMeta{UID: "owner-a"}); !ok {
+		t.Fatal("Children(owner-a) before delete = ok=false, want true")
+	}
+
+	oi.handleDelete(u)
+
+	if _, ok := oi.Children(&metav1.ObjectMeta{UID: "owner-a"}); ok {
+		t.Fatal("Children(owner-a) after delete = ok=true, want false")
+	}
+	if len(oi.childrenByUID) != 0 {
+		t.Fatalf("childrenByUID = %+v, want empty: the now-childless owner entry must be cleaned up", oi.childrenByUID)
+	}
+}
+
+func TestOwnerIndex_Children_sameRepresentationAsFallback(t *testing.T) {
+	oi := NewOwnerIndex(nil, nil)
+
+	child := testPod("ns1", "pod-1", "child-uid", nil, []metav1.OwnerReference{
+		{UID: "owner-a", Controller: boolPtr(true)},
+	})
+	oi.handleUpsert(toUnstructured(t, child))
+
+	children, ok := oi.Children(&metav1.ObjectMeta{UID: "owner-a"})
+	if !ok || len(children) != 1 {
+		t.Fatalf("Children(owner-a) = %+v, %v, want one child", children, ok)
+	}
+
+	if _, ok := children[0].(*unstructured.Unstructured); !ok {
+		t.Fatalf("Children returned %T, want *unstructured.Unstructured to match CacheQueryer.findChildren's fallback representation", children[0])
+	}
+}
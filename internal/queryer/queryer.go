@@ -9,23 +9,17 @@ import (
 	"github.com/pkg/errors"
 	"go.opencensus.io/trace"
 	"golang.org/x/sync/errgroup"
-	appsv1 "k8s.io/api/apps/v1"
-	batchv1beta1 "k8s.io/api/batch/v1beta1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/api/extensions/v1beta1"
 	extv1beta1 "k8s.io/api/extensions/v1beta1"
-	apiequality "k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
 	kLabels "k8s.io/apimachinery/pkg/labels"
 	kruntime "k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/discovery"
 	"k8s.io/client-go/kubernetes/scheme"
-	"k8s.io/kubernetes/pkg/apis/apps"
-	"k8s.io/kubernetes/pkg/apis/batch"
-	"k8s.io/kubernetes/pkg/apis/core"
 )
 
 //go:generate mockgen -destination=./fake/mock_queryer.go -package=fake github.com/heptio/developer-dash/internal/queryer Queryer
@@ -45,30 +39,74 @@ type CacheQueryer struct {
 	cache           cache.Cache
 	discoveryClient discovery.DiscoveryInterface
 
-	children        map[types.UID][]kruntime.Object
-	podsForServices map[types.UID][]*corev1.Pod
-	owner           map[cacheutil.Key]kruntime.Object
-
-	mu sync.Mutex
+	// children and podsForServices are TTL-only (defaultMemoTTL, no
+	// cache.Cache.OnChange hook): each memoized entry fans out across an
+	// open-ended set of kinds/keys (every namespaced resource for children,
+	// every Pod in the service's namespace for podsForServices), so there is
+	// no single cacheutil.Key to subscribe on whose change would mean "this
+	// result is now stale" — a newly created child or newly matching pod
+	// wouldn't be covered by a subscription taken out before it existed.
+	// owner, in contrast, resolves one well-known key and so can be (and is,
+	// via watchOwnerOnce) invalidated precisely. Callers needing immediate
+	// consistency for children/podsForServices should use WithOwnerIndex,
+	// which is kept current by informer events rather than polling.
+	children        *memoCache
+	podsForServices *memoCache
+	owner           *memoCache
+
+	metadataOnlyChildren bool
+	ownerIndex           *OwnerIndex
+
+	ownerWatchesMu sync.Mutex
+	ownerWatches   map[cacheutil.Key]struct{}
 }
 
 var _ Queryer = (*CacheQueryer)(nil)
 
-func New(c cache.Cache, discoveryClient discovery.DiscoveryInterface) *CacheQueryer {
-	return &CacheQueryer{
+// Option configures optional behavior on a CacheQueryer.
+type Option func(*CacheQueryer)
+
+// WithMetadataOnlyChildren makes Children filter candidate owners using
+// PartialObjectMetadata listings (via cache.Cache.ListMetadata) rather than
+// fully hydrated objects, fetching the full object with a follow-up Get only
+// for survivors. This trades an extra round-trip for survivors against the
+// memory cost of materializing every namespaced resource on every call,
+// which matters on clusters with a large number of CRDs.
+func WithMetadataOnlyChildren(enabled bool) Option {
+	return func(cq *CacheQueryer) {
+		cq.metadataOnlyChildren = enabled
+	}
+}
+
+// WithOwnerIndex makes Children, PodsForService, and ServicesForPod consult
+// an OwnerIndex first, falling back to the cache fan-out only when the
+// index has no entry (e.g. before it has finished its initial sync).
+func WithOwnerIndex(index *OwnerIndex) Option {
+	return func(cq *CacheQueryer) {
+		cq.ownerIndex = index
+	}
+}
+
+func New(c cache.Cache, discoveryClient discovery.DiscoveryInterface, options ...Option) *CacheQueryer {
+	cq := &CacheQueryer{
 		cache:           c,
 		discoveryClient: discoveryClient,
 
-		children:        make(map[types.UID][]kruntime.Object),
-		podsForServices: make(map[types.UID][]*corev1.Pod),
-		owner:           make(map[cacheutil.Key]kruntime.Object),
+		children:        newMemoCache("children", defaultMemoTTL, defaultMemoCapacity),
+		podsForServices: newMemoCache("podsForServices", defaultMemoTTL, defaultMemoCapacity),
+		owner:           newMemoCache("owner", defaultMemoTTL, defaultMemoCapacity),
+
+		ownerWatches: make(map[cacheutil.Key]struct{}),
+	}
+
+	for _, option := range options {
+		option(cq)
 	}
+
+	return cq
 }
 
 func (cq *CacheQueryer) Children(ctx context.Context, owner metav1.Object) ([]kruntime.Object, error) {
-	cq.mu.Lock()
-	defer cq.mu.Unlock()
-
 	if owner == nil {
 		return nil, errors.New("owner is nil")
 	}
@@ -76,20 +114,27 @@ func (cq *CacheQueryer) Children(ctx context.Context, owner metav1.Object) ([]kr
 	ctx, span := trace.StartSpan(ctx, "queryer:Children")
 	defer span.End()
 
-	cached, ok := cq.children[owner.GetUID()]
+	if cq.ownerIndex != nil {
+		if indexed, ok := cq.ownerIndex.Children(owner); ok {
+			return indexed, nil
+		}
+	}
 
-	if ok {
-		return cached, nil
+	value, err := cq.children.getOrFill(ctx, owner.GetUID(), func() (interface{}, error) {
+		return cq.findChildren(ctx, owner)
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	var children []kruntime.Object
+	return value.([]kruntime.Object), nil
+}
 
-	ch := make(chan kruntime.Object)
-	go func() {
-		for child := range ch {
-			children = append(children, child)
-		}
-	}()
+// findChildren fans out a List (or, with WithMetadataOnlyChildren, a
+// ListMetadata followed by a per-survivor Get) across every listable+
+// watchable namespaced kind, returning those objects controlled by owner.
+func (cq *CacheQueryer) findChildren(ctx context.Context, owner metav1.Object) ([]kruntime.Object, error) {
+	var children []kruntime.Object
 
 	resourceLists, err := cq.discoveryClient.ServerResources()
 	if err != nil {
@@ -123,6 +168,36 @@ func (cq *CacheQueryer) Children(ctx context.Context, owner metav1.Object) ([]kr
 			}
 
 			g.Go(func() error {
+				if cq.metadataOnlyChildren {
+					metadataList, err := cq.cache.ListMetadata(ctx, key)
+					if err != nil {
+						return errors.Wrapf(err, "unable to retrieve metadata for %+v", key)
+					}
+
+					for _, partial := range metadataList {
+						if !metav1.IsControlledBy(partial, owner) {
+							continue
+						}
+
+						childKey := key
+						childKey.Name = partial.GetName()
+
+						object, err := cq.cache.Get(ctx, childKey)
+						if err != nil {
+							return errors.Wrapf(err, "unable to retrieve %+v", childKey)
+						}
+						if object == nil {
+							continue
+						}
+
+						mu.Lock()
+						children = append(children, object)
+						mu.Unlock()
+					}
+
+					return nil
+				}
+
 				objects, err := cq.cache.List(ctx, key)
 				if err != nil {
 					return errors.Wrapf(err, "unable to retrieve %+v", key)
@@ -145,10 +220,6 @@ func (cq *CacheQueryer) Children(ctx context.Context, owner metav1.Object) ([]kr
 		return nil, errors.Wrap(err, "find children")
 	}
 
-	close(ch)
-
-	cq.children[owner.GetUID()] = children
-
 	return children, nil
 }
 
@@ -164,26 +235,50 @@ func (cq *CacheQueryer) Events(ctx context.Context, object metav1.Object) ([]*co
 
 	u := &unstructured.Unstructured{Object: m}
 
-	key := cacheutil.Key{
-		Namespace:  u.GetNamespace(),
+	eventKey := cacheutil.Key{
 		APIVersion: "v1",
 		Kind:       "Event",
 	}
 
-	allEvents, err := cq.cache.List(ctx, key)
+	indexedEvents, indexed, err := cq.cache.ListByFieldSelector(ctx, eventKey, eventSelectorFor(u))
+	if err != nil {
+		return nil, errors.Wrap(err, "listing events by field selector")
+	}
+	if indexed {
+		return convertEvents(indexedEvents)
+	}
+
+	// No field index is registered for Events; fall back to scanning and
+	// filtering client-side. A known UID is scoped cluster-wide, since it
+	// uniquely identifies the involved object regardless of which namespace
+	// recorded the event; otherwise the scan is scoped to the involved
+	// object's own namespace.
+	uid := u.GetUID()
+	if uid == "" {
+		eventKey.Namespace = u.GetNamespace()
+	}
+
+	allEvents, err := cq.cache.List(ctx, eventKey)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates, err := convertEvents(allEvents)
 	if err != nil {
 		return nil, err
 	}
 
 	var events []*corev1.Event
-	for _, unstructuredEvent := range allEvents {
-		event := &corev1.Event{}
-		err := kruntime.DefaultUnstructuredConverter.FromUnstructured(unstructuredEvent.Object, event)
-		if err != nil {
-			return nil, err
+	for _, event := range candidates {
+		involvedObject := event.InvolvedObject
+
+		if uid != "" {
+			if involvedObject.UID == uid {
+				events = append(events, event)
+			}
+			continue
 		}
 
-		involvedObject := event.InvolvedObject
 		if involvedObject.Namespace == u.GetNamespace() &&
 			involvedObject.APIVersion == u.GetAPIVersion() &&
 			involvedObject.Kind == u.GetKind() &&
@@ -195,6 +290,36 @@ func (cq *CacheQueryer) Events(ctx context.Context, object metav1.Object) ([]*co
 	return events, nil
 }
 
+// eventSelectorFor builds a field selector matching Events whose
+// involvedObject refers to u. It prefers involvedObject.uid, which is
+// unique across namespaces and so also covers an Event recorded in a
+// different namespace than the object it refers to, falling back to the
+// namespace/apiVersion/kind/name tuple when u has no UID set.
+func eventSelectorFor(u *unstructured.Unstructured) fields.Selector {
+	if uid := u.GetUID(); uid != "" {
+		return fields.OneTermEqualSelector("involvedObject.uid", string(uid))
+	}
+
+	return fields.AndSelectors(
+		fields.OneTermEqualSelector("involvedObject.namespace", u.GetNamespace()),
+		fields.OneTermEqualSelector("involvedObject.apiVersion", u.GetAPIVersion()),
+		fields.OneTermEqualSelector("involvedObject.kind", u.GetKind()),
+		fields.OneTermEqualSelector("involvedObject.name", u.GetName()),
+	)
+}
+
+func convertEvents(objects []*unstructured.Unstructured) ([]*corev1.Event, error) {
+	var events []*corev1.Event
+	for _, object := range objects {
+		event := &corev1.Event{}
+		if err := kruntime.DefaultUnstructuredConverter.FromUnstructured(object.Object, event); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
 func (cq *CacheQueryer) IngressesForService(ctx context.Context, service *corev1.Service) ([]*v1beta1.Ingress, error) {
 	if service == nil {
 		return nil, errors.New("nil service")
@@ -254,9 +379,6 @@ func (cq *CacheQueryer) listIngressBackends(ingress v1beta1.Ingress) []extv1beta
 }
 
 func (cq *CacheQueryer) OwnerReference(ctx context.Context, namespace string, ownerReference metav1.OwnerReference) (kruntime.Object, error) {
-	cq.mu.Lock()
-	defer cq.mu.Unlock()
-
 	key := cacheutil.Key{
 		Namespace:  namespace,
 		APIVersion: ownerReference.APIVersion,
@@ -264,60 +386,97 @@ func (cq *CacheQueryer) OwnerReference(ctx context.Context, namespace string, ow
 		Name:       ownerReference.Name,
 	}
 
-	object, ok := cq.owner[key]
-	if ok {
-		return object, nil
-	}
+	value, err := cq.owner.getOrFill(ctx, key, func() (interface{}, error) {
+		owner, err := cq.cache.Get(ctx, key)
+		if err != nil {
+			return nil, err
+		}
 
-	owner, err := cq.cache.Get(ctx, key)
+		if err := cq.watchOwnerOnce(key); err != nil {
+			return nil, err
+		}
+
+		return owner, nil
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	cq.owner[key] = owner
-
+	owner, _ := value.(kruntime.Object)
 	return owner, nil
 }
 
-func (cq *CacheQueryer) PodsForService(ctx context.Context, service *corev1.Service) ([]*corev1.Pod, error) {
-	cq.mu.Lock()
-	defer cq.mu.Unlock()
+// watchOwnerOnce registers a cache.Cache.OnChange hook that invalidates
+// key's memoized entry, exactly once per key for the lifetime of cq.
+// OwnerReference's fill runs again on every TTL expiry or LRU eviction of
+// key, so this must not register a new handler on each of those misses.
+func (cq *CacheQueryer) watchOwnerOnce(key cacheutil.Key) error {
+	cq.ownerWatchesMu.Lock()
+	defer cq.ownerWatchesMu.Unlock()
 
-	if service == nil {
-		return nil, errors.New("nil service")
+	if _, ok := cq.ownerWatches[key]; ok {
+		return nil
 	}
 
-	cached, ok := cq.podsForServices[service.UID]
-	if ok {
-		return cached, nil
+	if err := cq.cache.OnChange(key, func() { cq.owner.invalidate(key) }); err != nil {
+		return errors.Wrap(err, "registering owner invalidation hook")
 	}
 
-	key := cacheutil.Key{
-		Namespace:  service.Namespace,
-		APIVersion: "v1",
-		Kind:       "Pod",
+	cq.ownerWatches[key] = struct{}{}
+	return nil
+}
+
+func (cq *CacheQueryer) PodsForService(ctx context.Context, service *corev1.Service) ([]*corev1.Pod, error) {
+	if service == nil {
+		return nil, errors.New("nil service")
 	}
 
-	selector, err := cq.getSelector(service)
-	if err != nil {
-		return nil, errors.Wrapf(err, "creating pod selector for service: %v", service.Name)
+	if cq.ownerIndex != nil {
+		if indexed, ok := cq.ownerIndex.PodsForService(service); ok {
+			return indexed, nil
+		}
 	}
-	pods, err := cq.loadPods(ctx, key, selector)
+
+	value, err := cq.podsForServices.getOrFill(ctx, service.UID, func() (interface{}, error) {
+		key := cacheutil.Key{
+			Namespace:  service.Namespace,
+			APIVersion: "v1",
+			Kind:       "Pod",
+		}
+
+		selector, err := getSelector(service)
+		if err != nil {
+			return nil, errors.Wrapf(err, "creating pod selector for service: %v", service.Name)
+		}
+		pods, err := cq.loadPods(ctx, key, selector)
+		if err != nil {
+			return nil, errors.Wrapf(err, "fetching pods for service: %v", service.Name)
+		}
+
+		return pods, nil
+	})
 	if err != nil {
-		return nil, errors.Wrapf(err, "fetching pods for service: %v", service.Name)
+		return nil, err
 	}
 
-	cq.podsForServices[service.UID] = pods
-
-	return pods, nil
+	return value.([]*corev1.Pod), nil
 }
 
-func (cq *CacheQueryer) loadPods(ctx context.Context, key cacheutil.Key, selector *metav1.LabelSelector) ([]*corev1.Pod, error) {
+func (cq *CacheQueryer) loadPods(ctx context.Context, key cacheutil.Key, labelSelector *metav1.LabelSelector) ([]*corev1.Pod, error) {
 	objects, err := cq.cache.List(ctx, key)
 	if err != nil {
 		return nil, err
 	}
 
+	// metav1.LabelSelectorAsSelector(nil) returns labels.Nothing(), so a
+	// Service with no spec.selector (e.g. ExternalName, or a
+	// manually-managed Endpoints object) matches no pods rather than every
+	// pod in the namespace.
+	selector, err := metav1.LabelSelectorAsSelector(labelSelector)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid selector")
+	}
+
 	var list []*corev1.Pod
 
 	for _, object := range objects {
@@ -330,13 +489,14 @@ func (cq *CacheQueryer) loadPods(ctx context.Context, key cacheutil.Key, selecto
 			return nil, err
 		}
 
-		podSelector := &metav1.LabelSelector{
-			MatchLabels: pod.GetLabels(),
+		// Subset matching, consistent with ServicesForPod and
+		// OwnerIndex.associateLocked: the selector's labels must all be
+		// present on the pod, not an exact match of the full label set.
+		if selector.Empty() || !selector.Matches(kLabels.Set(pod.GetLabels())) {
+			continue
 		}
 
-		if selector == nil || isEqualSelector(selector, podSelector) {
-			list = append(list, pod)
-		}
+		list = append(list, pod)
 	}
 
 	return list, nil
@@ -384,6 +544,12 @@ func (cq *CacheQueryer) ServicesForPod(ctx context.Context, pod *corev1.Pod) ([]
 		return nil, errors.New("nil pod")
 	}
 
+	if cq.ownerIndex != nil {
+		if indexed, ok := cq.ownerIndex.ServicesForPod(pod); ok {
+			return indexed, nil
+		}
+	}
+
 	key := cacheutil.Key{
 		Namespace:  pod.Namespace,
 		APIVersion: "v1",
@@ -402,7 +568,7 @@ func (cq *CacheQueryer) ServicesForPod(ctx context.Context, pod *corev1.Pod) ([]
 		if err = copyObjectMeta(svc, u); err != nil {
 			return nil, errors.Wrap(err, "copying object metadata")
 		}
-		labelSelector, err := cq.getSelector(svc)
+		labelSelector, err := getSelector(svc)
 		if err != nil {
 			return nil, errors.Wrapf(err, "creating pod selector for service: %v", svc.Name)
 		}
@@ -419,56 +585,16 @@ func (cq *CacheQueryer) ServicesForPod(ctx context.Context, pod *corev1.Pod) ([]
 	return results, nil
 }
 
-func (cq *CacheQueryer) getSelector(object kruntime.Object) (*metav1.LabelSelector, error) {
-	switch t := object.(type) {
-	case *appsv1.DaemonSet:
-		return t.Spec.Selector, nil
-	case *appsv1.StatefulSet:
-		return t.Spec.Selector, nil
-	case *batchv1beta1.CronJob:
-		return nil, nil
-	case *corev1.ReplicationController:
-		selector := &metav1.LabelSelector{
-			MatchLabels: t.Spec.Selector,
-		}
-		return selector, nil
-	case *v1beta1.ReplicaSet:
-		return t.Spec.Selector, nil
-	case *appsv1.ReplicaSet:
-		return t.Spec.Selector, nil
-	case *appsv1.Deployment:
-		return t.Spec.Selector, nil
-	case *corev1.Service:
-		selector := &metav1.LabelSelector{
-			MatchLabels: t.Spec.Selector,
-		}
-		return selector, nil
-	case *apps.DaemonSet:
-		return t.Spec.Selector, nil
-	case *apps.StatefulSet:
-		return t.Spec.Selector, nil
-	case *batch.CronJob:
-		return nil, nil
-	case *core.ReplicationController:
-		selector := &metav1.LabelSelector{
-			MatchLabels: t.Spec.Selector,
-		}
-		return selector, nil
-	case *apps.ReplicaSet:
-		return t.Spec.Selector, nil
-	case *apps.Deployment:
-		return t.Spec.Selector, nil
-	case *core.Service:
-		selector := &metav1.LabelSelector{
-			MatchLabels: t.Spec.Selector,
-		}
-		return selector, nil
-	default:
-		return nil, errors.Errorf("unable to retrieve selector for type %T", object)
-	}
+// partialObject is satisfied by both *unstructured.Unstructured and
+// *metav1.PartialObjectMetadata, letting copyObjectMeta populate an object's
+// metadata from either a fully hydrated object or a metadata-only
+// projection.
+type partialObject interface {
+	metav1.Object
+	kruntime.Object
 }
 
-func copyObjectMeta(to interface{}, from *unstructured.Unstructured) error {
+func copyObjectMeta(to interface{}, from partialObject) error {
 	object, ok := to.(metav1.Object)
 	if !ok {
 		return errors.Errorf("%T is not an object", to)
@@ -478,8 +604,9 @@ func copyObjectMeta(to interface{}, from *unstructured.Unstructured) error {
 	if err != nil {
 		return errors.Wrapf(err, "accessing type meta")
 	}
-	t.SetAPIVersion(from.GetAPIVersion())
-	t.SetKind(from.GetObjectKind().GroupVersionKind().Kind)
+	gvk := from.GetObjectKind().GroupVersionKind()
+	t.SetAPIVersion(gvk.GroupVersion().String())
+	t.SetKind(gvk.Kind)
 
 	object.SetNamespace(from.GetNamespace())
 	object.SetName(from.GetName())
@@ -501,27 +628,6 @@ func copyObjectMeta(to interface{}, from *unstructured.Unstructured) error {
 	return nil
 }
 
-// extraKeys are keys that should be ignored in labels. These keys are added
-// by tools or by Kubernetes itself.
-var extraKeys = []string{
-	"statefulset.kubernetes.io/pod-name",
-	appsv1.DefaultDeploymentUniqueLabelKey,
-	"controller-revision-hash",
-	"pod-template-generation",
-}
-
-func isEqualSelector(s1, s2 *metav1.LabelSelector) bool {
-	s1Copy := s1.DeepCopy()
-	s2Copy := s2.DeepCopy()
-
-	for _, key := range extraKeys {
-		delete(s1Copy.MatchLabels, key)
-		delete(s2Copy.MatchLabels, key)
-	}
-
-	return apiequality.Semantic.DeepEqual(s1Copy, s2Copy)
-}
-
 func containsBackend(lst []v1beta1.IngressBackend, s string) bool {
 	for _, item := range lst {
 		if item.ServiceName == s {
@@ -539,4 +645,4 @@ func containsString(s string, sl []string) bool {
 	}
 
 	return false
-}
\ No newline at end of file
+}
@@ -0,0 +1,195 @@
+package queryer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/heptio/developer-dash/internal/cache"
+	cacheutil "github.com/heptio/developer-dash/internal/cache/util"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	kruntime "k8s.io/apimachinery/pkg/runtime"
+	clientgocache "k8s.io/client-go/tools/cache"
+)
+
+// fakeCache is a minimal cache.Cache stand-in for exercising Events' indexed
+// and fallback paths without a real informer-backed cache.
+type fakeCache struct {
+	listByFieldSelectorFunc func(ctx context.Context, key cacheutil.Key, selector fields.Selector) ([]*unstructured.Unstructured, bool, error)
+	listFunc                func(ctx context.Context, key cacheutil.Key) ([]*unstructured.Unstructured, error)
+}
+
+var _ cache.Cache = (*fakeCache)(nil)
+
+func (f *fakeCache) List(ctx context.Context, key cacheutil.Key) ([]*unstructured.Unstructured, error) {
+	if f.listFunc == nil {
+		return nil, nil
+	}
+	return f.listFunc(ctx, key)
+}
+
+func (f *fakeCache) Get(ctx context.Context, key cacheutil.Key) (*unstructured.Unstructured, error) {
+	return nil, nil
+}
+
+func (f *fakeCache) ListMetadata(ctx context.Context, key cacheutil.Key) ([]*metav1.PartialObjectMetadata, error) {
+	return nil, nil
+}
+
+func (f *fakeCache) ListByFieldSelector(ctx context.Context, key cacheutil.Key, selector fields.Selector) ([]*unstructured.Unstructured, bool, error) {
+	if f.listByFieldSelectorFunc == nil {
+		return nil, false, nil
+	}
+	return f.listByFieldSelectorFunc(ctx, key, selector)
+}
+
+func (f *fakeCache) AddEventHandler(ctx context.Context, key cacheutil.Key, handler clientgocache.ResourceEventHandler) error {
+	return nil
+}
+
+func (f *fakeCache) OnChange(key cacheutil.Key, handler func()) error {
+	return nil
+}
+
+func unstructuredEvent(t *testing.T, namespace, name string, involved corev1.ObjectReference) *unstructured.Unstructured {
+	t.Helper()
+
+	event := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+		},
+		InvolvedObject: involved,
+	}
+
+	m, err := kruntime.DefaultUnstructuredConverter.ToUnstructured(event)
+	if err != nil {
+		t.Fatalf("converting event to unstructured: %v", err)
+	}
+	return &unstructured.Unstructured{Object: m}
+}
+
+func TestCacheQueryer_Events_indexed(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "ns1",
+			Name:      "pod-1",
+			UID:       "pod-uid",
+		},
+	}
+
+	want := unstructuredEvent(t, "ns1", "event-1", corev1.ObjectReference{UID: "pod-uid"})
+
+	var listCalled bool
+	c := &fakeCache{
+		listByFieldSelectorFunc: func(ctx context.Context, key cacheutil.Key, selector fields.Selector) ([]*unstructured.Unstructured, bool, error) {
+			if got, want := selector.String(), "involvedObject.uid=pod-uid"; got != want {
+				t.Errorf("selector = %q, want %q", got, want)
+			}
+			return []*unstructured.Unstructured{want}, true, nil
+		},
+		listFunc: func(ctx context.Context, key cacheutil.Key) ([]*unstructured.Unstructured, error) {
+			listCalled = true
+			return nil, nil
+		},
+	}
+
+	cq := New(c, nil)
+
+	events, err := cq.Events(context.Background(), pod)
+	if err != nil {
+		t.Fatalf("Events returned error: %v", err)
+	}
+	if listCalled {
+		t.Fatal("Events fell back to List despite an indexed result")
+	}
+	if len(events) != 1 || events[0].Name != "event-1" {
+		t.Fatalf("Events = %+v, want a single event named event-1", events)
+	}
+}
+
+func TestCacheQueryer_Events_fallbackByUID_crossNamespace(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "ns1",
+			Name:      "pod-1",
+			UID:       "pod-uid",
+		},
+	}
+
+	matching := unstructuredEvent(t, "kube-system", "event-1", corev1.ObjectReference{UID: "pod-uid"})
+	other := unstructuredEvent(t, "ns1", "event-2", corev1.ObjectReference{UID: "other-uid"})
+
+	c := &fakeCache{
+		listByFieldSelectorFunc: func(ctx context.Context, key cacheutil.Key, selector fields.Selector) ([]*unstructured.Unstructured, bool, error) {
+			return nil, false, nil
+		},
+		listFunc: func(ctx context.Context, key cacheutil.Key) ([]*unstructured.Unstructured, error) {
+			if key.Namespace != "" {
+				t.Errorf("List scoped to namespace %q, want cluster-wide (empty) since the object has a UID", key.Namespace)
+			}
+			return []*unstructured.Unstructured{matching, other}, nil
+		},
+	}
+
+	cq := New(c, nil)
+
+	events, err := cq.Events(context.Background(), pod)
+	if err != nil {
+		t.Fatalf("Events returned error: %v", err)
+	}
+	if len(events) != 1 || events[0].Name != "event-1" {
+		t.Fatalf("Events = %+v, want only event-1, recorded in a different namespace than its involvedObject", events)
+	}
+}
+
+func TestCacheQueryer_Events_fallbackByTuple_noUID(t *testing.T) {
+	svc := &corev1.Service{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "Service",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "ns1",
+			Name:      "svc-1",
+		},
+	}
+
+	involved := corev1.ObjectReference{
+		Namespace:  "ns1",
+		APIVersion: "v1",
+		Kind:       "Service",
+		Name:       "svc-1",
+	}
+	matching := unstructuredEvent(t, "ns1", "event-1", involved)
+	other := unstructuredEvent(t, "ns1", "event-2", corev1.ObjectReference{
+		Namespace:  "ns1",
+		APIVersion: "v1",
+		Kind:       "Service",
+		Name:       "svc-2",
+	})
+
+	c := &fakeCache{
+		listByFieldSelectorFunc: func(ctx context.Context, key cacheutil.Key, selector fields.Selector) ([]*unstructured.Unstructured, bool, error) {
+			return nil, false, nil
+		},
+		listFunc: func(ctx context.Context, key cacheutil.Key) ([]*unstructured.Unstructured, error) {
+			if key.Namespace != "ns1" {
+				t.Errorf("List scoped to namespace %q, want %q since the object has no UID", key.Namespace, "ns1")
+			}
+			return []*unstructured.Unstructured{matching, other}, nil
+		},
+	}
+
+	cq := New(c, nil)
+
+	events, err := cq.Events(context.Background(), svc)
+	if err != nil {
+		t.Fatalf("Events returned error: %v", err)
+	}
+	if len(events) != 1 || events[0].Name != "event-1" {
+		t.Fatalf("Events = %+v, want only event-1, matching on namespace/apiVersion/kind/name", events)
+	}
+}
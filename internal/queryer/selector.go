@@ -0,0 +1,119 @@
+package queryer
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	kruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+// defaultSelectorPath is where a workload's pod selector conventionally
+// lives: either a *metav1.LabelSelector (matchLabels/matchExpressions), as
+// used by Deployment, ReplicaSet, DaemonSet, StatefulSet, and most
+// selector-bearing CRDs, or a bare label map, as used by Service and
+// ReplicationController.
+var defaultSelectorPath = []string{"spec", "selector"}
+
+var selectorPaths = struct {
+	mu    sync.RWMutex
+	paths map[schema.GroupVersionKind][]string
+}{paths: make(map[schema.GroupVersionKind][]string)}
+
+// RegisterSelectorPath tells getSelector where to find a pod selector for
+// gvk when it doesn't live at the conventional spec.selector path, e.g. a
+// CRD whose selector is nested under spec.template.selector. Safe for
+// concurrent use.
+func RegisterSelectorPath(gvk schema.GroupVersionKind, path []string) {
+	selectorPaths.mu.Lock()
+	defer selectorPaths.mu.Unlock()
+
+	selectorPaths.paths[gvk] = path
+}
+
+func selectorPathFor(gvk schema.GroupVersionKind) []string {
+	selectorPaths.mu.RLock()
+	defer selectorPaths.mu.RUnlock()
+
+	if path, ok := selectorPaths.paths[gvk]; ok {
+		return path
+	}
+	return defaultSelectorPath
+}
+
+// getSelector extracts object's pod selector, decoding it to unstructured
+// first if necessary. It replaces a hand-maintained type switch over
+// concrete API types: any object whose selector lives at the conventional
+// spec.selector path (or a path registered with RegisterSelectorPath) works
+// uniformly here, including CRD-managed workloads. Returns a nil selector,
+// not an error, when object has no selector field at all.
+func getSelector(object kruntime.Object) (*metav1.LabelSelector, error) {
+	u, err := toUnstructuredObject(object)
+	if err != nil {
+		return nil, err
+	}
+
+	gvk := u.GroupVersionKind()
+	path := selectorPathFor(gvk)
+
+	raw, found, err := unstructured.NestedMap(u.Object, path...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading selector at %v for %v", path, gvk)
+	}
+	if !found {
+		return nil, nil
+	}
+
+	selector := &metav1.LabelSelector{}
+	if err := kruntime.DefaultUnstructuredConverter.FromUnstructured(raw, selector); err != nil {
+		return nil, errors.Wrapf(err, "converting selector for %v", gvk)
+	}
+
+	if len(selector.MatchLabels) == 0 && len(selector.MatchExpressions) == 0 {
+		// Service and ReplicationController (and CRDs that copy their shape)
+		// store a bare label map at this path instead of a LabelSelector.
+		if matchLabels := mapSelectorFromRaw(raw); len(matchLabels) > 0 {
+			selector.MatchLabels = matchLabels
+		}
+	}
+
+	return selector, nil
+}
+
+func mapSelectorFromRaw(raw map[string]interface{}) map[string]string {
+	matchLabels := make(map[string]string, len(raw))
+	for k, v := range raw {
+		if s, ok := v.(string); ok {
+			matchLabels[k] = s
+		}
+	}
+	return matchLabels
+}
+
+// toUnstructuredObject returns object as *unstructured.Unstructured,
+// resolving its GroupVersionKind via the client-go scheme when object is a
+// typed Go struct that doesn't carry one (e.g. it came straight off an
+// informer's typed lister rather than through an API call).
+func toUnstructuredObject(object kruntime.Object) (*unstructured.Unstructured, error) {
+	if u, ok := object.(*unstructured.Unstructured); ok {
+		return u, nil
+	}
+
+	m, err := kruntime.DefaultUnstructuredConverter.ToUnstructured(object)
+	if err != nil {
+		return nil, errors.Wrapf(err, "converting %T to unstructured", object)
+	}
+
+	u := &unstructured.Unstructured{Object: m}
+
+	if gvk := u.GroupVersionKind(); gvk.Empty() {
+		if gvks, _, err := scheme.Scheme.ObjectKinds(object); err == nil && len(gvks) > 0 {
+			u.SetGroupVersionKind(gvks[0])
+		}
+	}
+
+	return u, nil
+}